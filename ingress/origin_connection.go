@@ -3,9 +3,13 @@ package ingress
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"io"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	gws "github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
@@ -15,31 +19,220 @@ import (
 	"github.com/cloudflare/cloudflared/websocket"
 )
 
+// errMaxBytesExceeded is returned by a countingReader/countingWriter once
+// its configured cap has been reached, which unblocks the corresponding
+// io.Copy (or direct Read/Write loop) in Stream.
+var errMaxBytesExceeded = errors.New("ingress: stream exceeded max bytes")
+
+// aLongTimeAgo is used to force a blocked Read to return immediately via
+// SetReadDeadline, without actually tearing down the connection.
+var aLongTimeAgo = time.Unix(1, 0)
+
 // OriginConnection is a way to stream to a service running on the user's origin.
 // Different concrete implementations will stream different protocols as long as they are io.ReadWriters.
 type OriginConnection interface {
 	// Stream should generally be implemented as a bidirectional io.Copy.
-	Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger)
+	Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger, opts *StreamOptions) StreamStats
 	Close()
 }
 
-type streamHandlerFunc func(originConn io.ReadWriter, remoteConn net.Conn, log *zerolog.Logger)
+type streamHandlerFunc func(originConn io.ReadWriter, remoteConn net.Conn, log *zerolog.Logger, opts *StreamOptions) StreamStats
+
+// StreamMetrics receives byte-accounting events from Stream once it has
+// finished copying, so callers can log them or export them as Prometheus
+// counters.
+type StreamMetrics interface {
+	// Finished is called exactly once per Stream call with the final stats.
+	Finished(stats StreamStats)
+}
+
+// StreamOptions controls optional behavior of Stream: an idle timeout, a
+// per-direction byte cap, and a metrics sink. The zero value disables all
+// of them and preserves the original bare io.Copy behavior.
+type StreamOptions struct {
+	// IdleTimeout closes the stream if neither direction has read any bytes
+	// for this long. Zero disables the idle timeout.
+	IdleTimeout time.Duration
+	// MaxBytes terminates the stream once at least this many bytes have
+	// been copied in either direction. The cap is checked before each read,
+	// so the last read may carry the total past it. Zero means unlimited.
+	MaxBytes int64
+	// Metrics, if set, is notified once Stream finishes.
+	Metrics StreamMetrics
+}
+
+// StreamStats summarizes the result of a Stream call.
+type StreamStats struct {
+	BytesIn  int64
+	BytesOut int64
+	// Reason describes why the stream ended: "idle_timeout", "max_bytes"
+	// or "" for a normal close.
+	Reason string
+	Err    error
+}
+
+// countingReader wraps an io.Reader, tallying bytes read and, if maxBytes
+// is set, returning errMaxBytesExceeded once that many bytes have passed
+// through. n is an atomic.Int64 because callers may read it for stats
+// while the owning io.Copy goroutine is still running.
+type countingReader struct {
+	io.Reader
+	n        atomic.Int64
+	maxBytes int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	if cr.maxBytes > 0 && cr.n.Load() >= cr.maxBytes {
+		return 0, errMaxBytesExceeded
+	}
+	n, err := cr.Reader.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
+}
+
+// countingWriter is the Writer counterpart of countingReader, used on paths
+// where we only have a single io.ReadWriter to instrument (e.g. SOCKS over
+// WS) rather than two independent connections.
+type countingWriter struct {
+	io.Writer
+	n        atomic.Int64
+	maxBytes int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.maxBytes > 0 && cw.n.Load() >= cw.maxBytes {
+		return 0, errMaxBytesExceeded
+	}
+	n, err := cw.Writer.Write(p)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+// reasonTracker records the first reason a stream ended, e.g. "idle_timeout"
+// or "max_bytes". It may be written from multiple copy goroutines and the
+// idle timer's callback, and read once after they've all been observed to
+// have finished, so both setIfEmpty and get take the same lock.
+type reasonTracker struct {
+	mu     sync.Mutex
+	reason string
+}
+
+func (rt *reasonTracker) setIfEmpty(r string) {
+	rt.mu.Lock()
+	if rt.reason == "" {
+		rt.reason = r
+	}
+	rt.mu.Unlock()
+}
+
+func (rt *reasonTracker) get() string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.reason
+}
+
+// idleTimer fires onIdle after idleTimeout of inactivity; touch pushes the
+// deadline back out and may be called concurrently from multiple
+// goroutines, because (unlike a plain time.Timer read via its channel) it
+// is driven entirely by time.AfterFunc and nothing ever receives on a
+// channel that Reset could race with.
+type idleTimer struct {
+	timer       *time.Timer
+	idleTimeout time.Duration
+}
+
+func newIdleTimer(idleTimeout time.Duration, onIdle func()) *idleTimer {
+	return &idleTimer{
+		timer:       time.AfterFunc(idleTimeout, onIdle),
+		idleTimeout: idleTimeout,
+	}
+}
+
+func (t *idleTimer) touch() {
+	t.timer.Reset(t.idleTimeout)
+}
+
+func (t *idleTimer) Stop() {
+	t.timer.Stop()
+}
+
+// deadlineReader wraps an io.Reader and touches a shared idleTimer after
+// every successful Read, so that activity on either direction of a stream
+// resets the same deadline instead of each direction timing out on its own.
+type deadlineReader struct {
+	io.Reader
+	idle *idleTimer
+}
+
+func (dr *deadlineReader) Read(p []byte) (int, error) {
+	n, err := dr.Reader.Read(p)
+	if n > 0 {
+		dr.idle.touch()
+	}
+	return n, err
+}
+
+// deadlineWriter is the Writer counterpart of deadlineReader.
+type deadlineWriter struct {
+	io.Writer
+	idle *idleTimer
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	n, err := dw.Writer.Write(p)
+	if n > 0 {
+		dw.idle.touch()
+	}
+	return n, err
+}
+
+// Stream copies data to & from provided io.ReadWriters, optionally enforcing
+// an idle timeout and a per-direction byte cap, and reports the outcome
+// through opts.Metrics. opts may be nil, in which case Stream behaves like a
+// bare bidirectional io.Copy.
+func Stream(conn, backendConn io.ReadWriter, log *zerolog.Logger, opts *StreamOptions) StreamStats {
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+
+	inCounter := &countingReader{Reader: conn, maxBytes: opts.MaxBytes}
+	outCounter := &countingReader{Reader: backendConn, maxBytes: opts.MaxBytes}
+
+	var inReader, outReader io.Reader = inCounter, outCounter
+
+	reason := &reasonTracker{}
+
+	if opts.IdleTimeout > 0 {
+		var closeOnce sync.Once
+		idle := newIdleTimer(opts.IdleTimeout, func() {
+			reason.setIfEmpty("idle_timeout")
+			closeOnce.Do(func() {
+				closeReadWriter(conn)
+				closeReadWriter(backendConn)
+			})
+		})
+		defer idle.Stop()
+		inReader = &deadlineReader{Reader: inCounter, idle: idle}
+		outReader = &deadlineReader{Reader: outCounter, idle: idle}
+	}
 
-// Stream copies copy data to & from provided io.ReadWriters.
-func Stream(conn, backendConn io.ReadWriter, log *zerolog.Logger) {
 	proxyDone := make(chan struct{}, 2)
 
 	go func() {
-		_, err := io.Copy(conn, backendConn)
-		if err != nil {
+		_, err := io.Copy(backendConn, inReader)
+		if errors.Is(err, errMaxBytesExceeded) {
+			reason.setIfEmpty("max_bytes")
+		} else if err != nil {
 			log.Debug().Msgf("conn to backendConn copy: %v", err)
 		}
 		proxyDone <- struct{}{}
 	}()
 
 	go func() {
-		_, err := io.Copy(backendConn, conn)
-		if err != nil {
+		_, err := io.Copy(conn, outReader)
+		if errors.Is(err, errMaxBytesExceeded) {
+			reason.setIfEmpty("max_bytes")
+		} else if err != nil {
 			log.Debug().Msgf("backendConn to conn copy: %v", err)
 		}
 		proxyDone <- struct{}{}
@@ -47,21 +240,124 @@ func Stream(conn, backendConn io.ReadWriter, log *zerolog.Logger) {
 
 	// If one side is done, we are done.
 	<-proxyDone
+
+	stats := StreamStats{
+		BytesIn:  inCounter.n.Load(),
+		BytesOut: outCounter.n.Load(),
+		Reason:   reason.get(),
+	}
+	if opts.Metrics != nil {
+		opts.Metrics.Finished(stats)
+	}
+	return stats
+}
+
+// readDeadlineSetter is implemented by most real transport connections
+// (net.Conn, tls.Conn, quic streams, ...). Forcing a deadline in the past is
+// the most reliable way to unblock a Read that is stuck inside a wrapper
+// type that doesn't want to be closed outright.
+type readDeadlineSetter interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// closeReadWriter unblocks a stuck io.Copy when an idle timeout fires (a
+// byte cap ends only the copy that hit it): it prefers forcing the blocked Read to return via SetReadDeadline
+// and falls back to Close for read-writers that only support that.
+func closeReadWriter(rw io.ReadWriter) {
+	if ds, ok := rw.(readDeadlineSetter); ok {
+		ds.SetReadDeadline(aLongTimeAgo)
+		return
+	}
+	if c, ok := rw.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// closableReadWriter pairs a read/write wrapper (e.g. a websocket.Conn) with
+// the raw transport connection it was built from, so closeReadWriter can
+// always reach something that actually implements SetReadDeadline/Close
+// even when the wrapper itself doesn't.
+type closableReadWriter struct {
+	io.ReadWriter
+	raw io.ReadWriter
+}
+
+func (c *closableReadWriter) SetReadDeadline(t time.Time) error {
+	if ds, ok := c.raw.(readDeadlineSetter); ok {
+		return ds.SetReadDeadline(t)
+	}
+	return errors.New("ingress: underlying connection does not support SetReadDeadline")
+}
+
+func (c *closableReadWriter) Close() error {
+	if cl, ok := c.raw.(io.Closer); ok {
+		return cl.Close()
+	}
+	return errors.New("ingress: underlying connection does not support Close")
 }
 
 // DefaultStreamHandler is an implementation of streamHandlerFunc that
 // performs a two way io.Copy between originConn and remoteConn.
-func DefaultStreamHandler(originConn io.ReadWriter, remoteConn net.Conn, log *zerolog.Logger) {
-	Stream(originConn, remoteConn, log)
+func DefaultStreamHandler(originConn io.ReadWriter, remoteConn net.Conn, log *zerolog.Logger, opts *StreamOptions) StreamStats {
+	return Stream(originConn, remoteConn, log, opts)
 }
 
 // tcpConnection is an OriginConnection that directly streams to raw TCP.
 type tcpConnection struct {
 	conn net.Conn
+	// proxyProto, if set, makes Stream write a PROXY protocol header to
+	// conn describing the eyeball's address before any bytes are copied.
+	proxyProto ProxyProtocolVersion
+}
+
+// newTCPConnection wraps an already dialed raw TCP origin conn, optionally
+// prefixing the stream with a PROXY protocol header when proxyProto is
+// configured on the ingress rule.
+func newTCPConnection(conn net.Conn, proxyProto ProxyProtocolVersion) *tcpConnection {
+	return &tcpConnection{
+		conn:       conn,
+		proxyProto: proxyProto,
+	}
 }
 
-func (tc *tcpConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger) {
-	Stream(tunnelConn, tc.conn, log)
+// DialTCPOrigin dials originAddr over raw TCP and returns the
+// OriginConnection a tcp:// ingress rule streams through, along with the
+// context that must be passed to its Stream method. When proxyProto is
+// configured for the rule, the eyeball's address is attached to that
+// context so tcpConnection.Stream can write a PROXY protocol header before
+// any bytes are copied; the tunnel transport (HTTP/2 handler, QUIC stream
+// handler) is expected to call this with the eyeball address it read off
+// the inbound request.
+func DialTCPOrigin(ctx context.Context, originAddr string, eyeballAddr net.Addr, proxyProto ProxyProtocolVersion) (OriginConnection, context.Context, error) {
+	conn, err := net.Dial("tcp", originAddr)
+	if err != nil {
+		return nil, ctx, err
+	}
+	if proxyProto != ProxyProtocolNone && eyeballAddr != nil {
+		ctx = ContextWithEyeballAddr(ctx, eyeballAddr)
+	}
+	return newTCPConnection(conn, proxyProto), ctx, nil
+}
+
+// Stream writes a PROXY protocol header to the origin first, if configured.
+// Contract for a missing eyeball address: that's a plumbing gap in the
+// tunnel transport, not something the rule or the user's traffic caused, so
+// we log a warning and stream without the header rather than failing the
+// whole connection. Any other error building or writing the header (e.g. a
+// malformed address) is treated as fatal, since it means the configured
+// PROXY protocol mode cannot be honored at all.
+func (tc *tcpConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger, opts *StreamOptions) StreamStats {
+	if tc.proxyProto != ProxyProtocolNone {
+		if err := writeProxyProtocolHeader(ctx, tc.conn, tc.proxyProto); err != nil {
+			if errors.Is(err, errNoEyeballAddr) {
+				log.Warn().Msg("No eyeball address available for PROXY protocol; streaming to origin without a header")
+			} else {
+				log.Error().Err(err).Msg("Failed to write PROXY protocol header to origin")
+				return StreamStats{Err: err}
+			}
+		}
+	}
+	return Stream(tunnelConn, tc.conn, log, opts)
 }
 
 func (tc *tcpConnection) Close() {
@@ -74,8 +370,9 @@ type tcpOverWSConnection struct {
 	streamHandler streamHandlerFunc
 }
 
-func (wc *tcpOverWSConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger) {
-	wc.streamHandler(websocket.NewConn(ctx, tunnelConn, log), wc.conn, log)
+func (wc *tcpOverWSConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger, opts *StreamOptions) StreamStats {
+	wsConn := &closableReadWriter{ReadWriter: websocket.NewConn(ctx, tunnelConn, log), raw: tunnelConn}
+	return wc.streamHandler(wsConn, wc.conn, log, opts)
 }
 
 func (wc *tcpOverWSConnection) Close() {
@@ -88,8 +385,8 @@ type wsConnection struct {
 	resp   *http.Response
 }
 
-func (wsc *wsConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger) {
-	Stream(tunnelConn, wsc.wsConn.UnderlyingConn(), log)
+func (wsc *wsConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger, opts *StreamOptions) StreamStats {
+	return Stream(tunnelConn, wsc.wsConn.UnderlyingConn(), log, opts)
 }
 
 func (wsc *wsConnection) Close() {
@@ -118,8 +415,45 @@ type socksProxyOverWSConnection struct {
 	accessPolicy *ipaccess.Policy
 }
 
-func (sp *socksProxyOverWSConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger) {
-	socks.StreamNetHandler(websocket.NewConn(ctx, tunnelConn, log), sp.accessPolicy, log)
+func (sp *socksProxyOverWSConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger, opts *StreamOptions) StreamStats {
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+
+	wsConn := &closableReadWriter{ReadWriter: websocket.NewConn(ctx, tunnelConn, log), raw: tunnelConn}
+	inCounter := &countingReader{Reader: wsConn, maxBytes: opts.MaxBytes}
+	outCounter := &countingWriter{Writer: wsConn, maxBytes: opts.MaxBytes}
+
+	reason := &reasonTracker{}
+
+	var rw io.ReadWriter = struct {
+		io.Reader
+		io.Writer
+	}{inCounter, outCounter}
+
+	if opts.IdleTimeout > 0 {
+		idle := newIdleTimer(opts.IdleTimeout, func() {
+			reason.setIfEmpty("idle_timeout")
+			closeReadWriter(wsConn)
+		})
+		defer idle.Stop()
+		rw = struct {
+			io.Reader
+			io.Writer
+		}{&deadlineReader{Reader: inCounter, idle: idle}, &deadlineWriter{Writer: outCounter, idle: idle}}
+	}
+
+	socks.StreamNetHandler(rw, sp.accessPolicy, log)
+
+	stats := StreamStats{
+		BytesIn:  inCounter.n.Load(),
+		BytesOut: outCounter.n.Load(),
+		Reason:   reason.get(),
+	}
+	if opts.Metrics != nil {
+		opts.Metrics.Finished(stats)
+	}
+	return stats
 }
 
 func (sp *socksProxyOverWSConnection) Close() {
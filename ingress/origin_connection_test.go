@@ -0,0 +1,128 @@
+package ingress
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestStreamCopiesBothDirectionsAndCountsBytes(t *testing.T) {
+	log := zerolog.Nop()
+
+	connSide, connDriver := net.Pipe()
+	backendSide, backendDriver := net.Pipe()
+	defer connDriver.Close()
+	defer backendDriver.Close()
+
+	toOrigin := []byte("hello origin")
+	toEyeball := []byte("hello eyeball")
+
+	done := make(chan StreamStats, 1)
+	go func() {
+		done <- Stream(connSide, backendSide, &log, nil)
+	}()
+
+	go func() {
+		connDriver.Write(toOrigin)
+	}()
+	gotAtOrigin := make([]byte, len(toOrigin))
+	if _, err := io.ReadFull(backendDriver, gotAtOrigin); err != nil {
+		t.Fatalf("reading at origin: %v", err)
+	}
+	if string(gotAtOrigin) != string(toOrigin) {
+		t.Fatalf("origin got %q, want %q", gotAtOrigin, toOrigin)
+	}
+
+	go func() {
+		backendDriver.Write(toEyeball)
+	}()
+	gotAtEyeball := make([]byte, len(toEyeball))
+	if _, err := io.ReadFull(connDriver, gotAtEyeball); err != nil {
+		t.Fatalf("reading at eyeball: %v", err)
+	}
+	if string(gotAtEyeball) != string(toEyeball) {
+		t.Fatalf("eyeball got %q, want %q", gotAtEyeball, toEyeball)
+	}
+
+	connDriver.Close()
+	backendDriver.Close()
+
+	select {
+	case stats := <-done:
+		if stats.BytesIn != int64(len(toOrigin)) {
+			t.Errorf("BytesIn = %d, want %d", stats.BytesIn, len(toOrigin))
+		}
+		if stats.BytesOut != int64(len(toEyeball)) {
+			t.Errorf("BytesOut = %d, want %d", stats.BytesOut, len(toEyeball))
+		}
+		if stats.Reason != "" {
+			t.Errorf("Reason = %q, want empty for a normal close", stats.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not return after both sides closed")
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	log := zerolog.Nop()
+
+	connSide, connDriver := net.Pipe()
+	backendSide, backendDriver := net.Pipe()
+	defer connDriver.Close()
+	defer backendDriver.Close()
+
+	done := make(chan StreamStats, 1)
+	go func() {
+		done <- Stream(connSide, backendSide, &log, &StreamOptions{IdleTimeout: 20 * time.Millisecond})
+	}()
+
+	select {
+	case stats := <-done:
+		if stats.Reason != "idle_timeout" {
+			t.Errorf("Reason = %q, want %q", stats.Reason, "idle_timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not time out an idle connection")
+	}
+}
+
+func TestStreamMaxBytes(t *testing.T) {
+	log := zerolog.Nop()
+
+	connSide, connDriver := net.Pipe()
+	backendSide, backendDriver := net.Pipe()
+	defer connDriver.Close()
+	defer backendDriver.Close()
+
+	done := make(chan StreamStats, 1)
+	go func() {
+		done <- Stream(connSide, backendSide, &log, &StreamOptions{MaxBytes: 4})
+	}()
+
+	go func() {
+		// Each Write on net.Pipe rendezvous with exactly one Read, so
+		// writing in small chunks lets the cap trip between chunks
+		// rather than all being absorbed by a single large Read.
+		for _, chunk := range [][]byte{[]byte("aa"), []byte("bb"), []byte("cc"), []byte("dd")} {
+			if _, err := connDriver.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+	go io.Copy(io.Discard, backendDriver)
+
+	select {
+	case stats := <-done:
+		if stats.Reason != "max_bytes" {
+			t.Errorf("Reason = %q, want %q", stats.Reason, "max_bytes")
+		}
+		if stats.BytesIn < 4 {
+			t.Errorf("BytesIn = %d, want at least the configured cap of 4", stats.BytesIn)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not stop after exceeding MaxBytes")
+	}
+}
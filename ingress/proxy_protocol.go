@@ -0,0 +1,162 @@
+package ingress
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ProxyProtocolVersion selects which PROXY protocol header, if any,
+// tcpConnection writes to the origin before any bytes are streamed.
+type ProxyProtocolVersion int
+
+const (
+	// ProxyProtocolNone streams directly with no PROXY protocol header.
+	ProxyProtocolNone ProxyProtocolVersion = iota
+	// ProxyProtocolV1 writes the human-readable v1 header.
+	ProxyProtocolV1
+	// ProxyProtocolV2 writes the binary v2 header.
+	ProxyProtocolV2
+)
+
+// proxyProtocolV2Signature is the fixed 12-byte magic prefix of every
+// PROXY protocol v2 header.
+var proxyProtocolV2Signature = []byte{
+	0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A,
+}
+
+type eyeballAddrContextKey struct{}
+
+// ContextWithEyeballAddr attaches the eyeball's source address to ctx so it
+// survives down to tcpConnection.Stream regardless of which tunnel
+// transport (HTTP/2, QUIC) carried the request.
+func ContextWithEyeballAddr(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, eyeballAddrContextKey{}, addr)
+}
+
+// EyeballAddrFromContext returns the eyeball address attached by
+// ContextWithEyeballAddr, if any.
+func EyeballAddrFromContext(ctx context.Context) (net.Addr, bool) {
+	addr, ok := ctx.Value(eyeballAddrContextKey{}).(net.Addr)
+	return addr, ok
+}
+
+// errNoEyeballAddr is returned by writeProxyProtocolHeader when ctx carries
+// no eyeball address. tcpConnection.Stream treats this specific error as
+// non-fatal: see the contract note on that method.
+var errNoEyeballAddr = errors.New("ingress: no eyeball address in context to build PROXY protocol header")
+
+// writeProxyProtocolHeader writes a PROXY protocol header to conn
+// describing the eyeball's address (read from ctx) and conn's own remote
+// address as the destination.
+func writeProxyProtocolHeader(ctx context.Context, conn net.Conn, version ProxyProtocolVersion) error {
+	eyeballAddr, ok := EyeballAddrFromContext(ctx)
+	if !ok {
+		return errNoEyeballAddr
+	}
+	header, err := buildProxyProtocolHeader(version, eyeballAddr, conn.RemoteAddr())
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(header)
+	return err
+}
+
+// buildProxyProtocolHeader renders a PROXY protocol header (v1 or v2)
+// describing a connection from src to dst.
+func buildProxyProtocolHeader(version ProxyProtocolVersion, src, dst net.Addr) ([]byte, error) {
+	srcIP, srcPort, err := splitHostPortAddr(src)
+	if err != nil {
+		return nil, fmt.Errorf("ingress: invalid PROXY protocol source address: %w", err)
+	}
+	dstIP, dstPort, err := splitHostPortAddr(dst)
+	if err != nil {
+		return nil, fmt.Errorf("ingress: invalid PROXY protocol destination address: %w", err)
+	}
+
+	switch version {
+	case ProxyProtocolV1:
+		return buildProxyProtocolV1Header(srcIP, dstIP, srcPort, dstPort)
+	case ProxyProtocolV2:
+		return buildProxyProtocolV2Header(srcIP, dstIP, srcPort, dstPort)
+	default:
+		return nil, fmt.Errorf("ingress: unsupported PROXY protocol version %d", version)
+	}
+}
+
+func splitHostPortAddr(addr net.Addr) (net.IP, uint16, error) {
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("not an IP address: %s", host)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ip, uint16(port), nil
+}
+
+// buildProxyProtocolV1Header renders "PROXY TCP4/TCP6 src dst sport dport\r\n".
+// v1 has no way to express mixed address families in a single line, so src
+// and dst must both be IPv4 or both be IPv6.
+func buildProxyProtocolV1Header(src, dst net.IP, srcPort, dstPort uint16) ([]byte, error) {
+	srcV4, dstV4 := src.To4() != nil, dst.To4() != nil
+	if srcV4 != dstV4 {
+		return nil, fmt.Errorf("ingress: PROXY protocol v1 requires matching address families, got src=%s dst=%s", src, dst)
+	}
+	proto := "TCP4"
+	if !srcV4 {
+		proto = "TCP6"
+	}
+	header := fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, src.String(), dst.String(), srcPort, dstPort)
+	return []byte(header), nil
+}
+
+// buildProxyProtocolV2Header renders the binary v2 header: the 12-byte
+// signature, a version/command byte, a family/transport byte, a 2-byte
+// big-endian address length, then the addresses and ports themselves.
+// Like v1, it requires src and dst to share an address family rather than
+// silently v4-mapping one into the other's family byte.
+func buildProxyProtocolV2Header(src, dst net.IP, srcPort, dstPort uint16) ([]byte, error) {
+	src4, dst4 := src.To4(), dst.To4()
+	srcV4, dstV4 := src4 != nil, dst4 != nil
+	if srcV4 != dstV4 {
+		return nil, fmt.Errorf("ingress: PROXY protocol v2 requires matching address families, got src=%s dst=%s", src, dst)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(proxyProtocolV2Signature)
+	buf.WriteByte(0x21) // version 2, command PROXY
+
+	var addrFamily byte
+	var addrBytes []byte
+	if srcV4 {
+		addrFamily = 0x11 // AF_INET, STREAM
+		addrBytes = append(append([]byte{}, src4...), dst4...)
+	} else {
+		addrFamily = 0x21 // AF_INET6, STREAM
+		addrBytes = append(append([]byte{}, src.To16()...), dst.To16()...)
+	}
+	buf.WriteByte(addrFamily)
+
+	var portBytes [4]byte
+	binary.BigEndian.PutUint16(portBytes[0:2], srcPort)
+	binary.BigEndian.PutUint16(portBytes[2:4], dstPort)
+
+	var lengthBytes [2]byte
+	binary.BigEndian.PutUint16(lengthBytes[:], uint16(len(addrBytes)+len(portBytes)))
+
+	buf.Write(lengthBytes[:])
+	buf.Write(addrBytes)
+	buf.Write(portBytes[:])
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,128 @@
+package ingress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func mustAddr(t *testing.T, ipPort string) net.Addr {
+	t.Helper()
+	host, portStr, err := net.SplitHostPort(ipPort)
+	if err != nil {
+		t.Fatalf("SplitHostPort(%q): %v", ipPort, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("parsing port %q: %v", portStr, err)
+	}
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: port}
+}
+
+func TestBuildProxyProtocolV1Header(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		dst     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "tcp4",
+			src:  "10.0.0.1:1111",
+			dst:  "10.0.0.2:2222",
+			want: "PROXY TCP4 10.0.0.1 10.0.0.2 1111 2222\r\n",
+		},
+		{
+			name: "tcp6",
+			src:  "[::1]:1111",
+			dst:  "[::2]:2222",
+			want: "PROXY TCP6 ::1 ::2 1111 2222\r\n",
+		},
+		{
+			name:    "mixed families rejected",
+			src:     "10.0.0.1:1111",
+			dst:     "[::2]:2222",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := buildProxyProtocolHeader(ProxyProtocolV1, mustAddr(t, tt.src), mustAddr(t, tt.dst))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got header %q", header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(header) != tt.want {
+				t.Errorf("header = %q, want %q", header, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildProxyProtocolV2Header(t *testing.T) {
+	t.Run("tcp4", func(t *testing.T) {
+		header, err := buildProxyProtocolHeader(ProxyProtocolV2, mustAddr(t, "10.0.0.1:1111"), mustAddr(t, "10.0.0.2:2222"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.HasPrefix(header, proxyProtocolV2Signature) {
+			t.Fatalf("header missing v2 signature prefix: %x", header)
+		}
+		rest := header[len(proxyProtocolV2Signature):]
+		if rest[0] != 0x21 {
+			t.Errorf("version/command byte = %#x, want 0x21", rest[0])
+		}
+		if rest[1] != 0x11 {
+			t.Errorf("family byte = %#x, want 0x11 for TCP4", rest[1])
+		}
+		length := binary.BigEndian.Uint16(rest[2:4])
+		if length != 12 { // 4 + 4 addr bytes + 2 + 2 port bytes
+			t.Errorf("address length = %d, want 12", length)
+		}
+		addrAndPorts := rest[4:]
+		if len(addrAndPorts) != int(length) {
+			t.Fatalf("trailing bytes = %d, want %d", len(addrAndPorts), length)
+		}
+		if !bytes.Equal(addrAndPorts[0:4], net.ParseIP("10.0.0.1").To4()) {
+			t.Errorf("src addr = %v, want 10.0.0.1", addrAndPorts[0:4])
+		}
+		if !bytes.Equal(addrAndPorts[4:8], net.ParseIP("10.0.0.2").To4()) {
+			t.Errorf("dst addr = %v, want 10.0.0.2", addrAndPorts[4:8])
+		}
+		if got := binary.BigEndian.Uint16(addrAndPorts[8:10]); got != 1111 {
+			t.Errorf("src port = %d, want 1111", got)
+		}
+		if got := binary.BigEndian.Uint16(addrAndPorts[10:12]); got != 2222 {
+			t.Errorf("dst port = %d, want 2222", got)
+		}
+	})
+
+	t.Run("tcp6", func(t *testing.T) {
+		header, err := buildProxyProtocolHeader(ProxyProtocolV2, mustAddr(t, "[::1]:1111"), mustAddr(t, "[::2]:2222"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rest := header[len(proxyProtocolV2Signature):]
+		if rest[1] != 0x21 {
+			t.Errorf("family byte = %#x, want 0x21 for TCP6", rest[1])
+		}
+		length := binary.BigEndian.Uint16(rest[2:4])
+		if length != 36 { // 16 + 16 addr bytes + 2 + 2 port bytes
+			t.Errorf("address length = %d, want 36", length)
+		}
+	})
+
+	t.Run("mixed families rejected", func(t *testing.T) {
+		if _, err := buildProxyProtocolHeader(ProxyProtocolV2, mustAddr(t, "10.0.0.1:1111"), mustAddr(t, "[::2]:2222")); err == nil {
+			t.Fatal("expected an error for mixed address families")
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// serviceTCPScheme is the scheme a tcp:// ingress rule's service URL uses,
+// alongside the udpServiceScheme handled by DialUDPOrigin.
+const serviceTCPScheme = "tcp://"
+
+// DialOriginForService dials the OriginConnection a tcp:// or udp://
+// ingress rule's raw service URL (e.g. "tcp://localhost:8080" or
+// "udp://localhost:53") resolves to. This is the ingress rule parser's
+// single entry point for both schemes: it picks the scheme off rawServiceURL
+// and delegates to DialTCPOrigin or DialUDPOrigin accordingly. eyeballAddr
+// and proxyProto are only meaningful for the tcp:// path; udpIdleTimeout
+// only for the udp:// path.
+func DialOriginForService(ctx context.Context, rawServiceURL string, eyeballAddr net.Addr, proxyProto ProxyProtocolVersion, udpIdleTimeout time.Duration) (OriginConnection, context.Context, error) {
+	switch {
+	case isUDPService(rawServiceURL):
+		conn, err := DialUDPOrigin(strings.TrimPrefix(rawServiceURL, udpServiceScheme), udpIdleTimeout)
+		return conn, ctx, err
+	case strings.HasPrefix(rawServiceURL, serviceTCPScheme):
+		return DialTCPOrigin(ctx, strings.TrimPrefix(rawServiceURL, serviceTCPScheme), eyeballAddr, proxyProto)
+	default:
+		return nil, ctx, fmt.Errorf("ingress: unsupported service scheme in %q", rawServiceURL)
+	}
+}
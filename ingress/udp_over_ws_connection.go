@@ -0,0 +1,209 @@
+package ingress
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/websocket"
+)
+
+const (
+	// udpServiceScheme is the ingress rule service scheme that dials a UDP
+	// origin over udpOverWSConnection, alongside the existing tcp:// scheme.
+	udpServiceScheme = "udp://"
+
+	// maxDatagramFrameSize is both the size of the buffer used to read one
+	// datagram off the origin UDP socket and the cap enforced by
+	// readDatagramFrame on the length prefix read off the WS side, so a
+	// corrupt prefix can't make it allocate an oversized payload buffer. Set
+	// to the largest UDP payload a single IPv4 datagram can carry (65535 -
+	// 8-byte UDP header - 20-byte IP header), which also keeps it a valid
+	// uint16 value like the length prefix itself.
+	maxDatagramFrameSize = 65507
+
+	// defaultUDPIdleTimeout closes a flow's origin socket if neither
+	// direction has carried a datagram for this long.
+	defaultUDPIdleTimeout = 60 * time.Second
+)
+
+// errDatagramTooLarge is returned by readDatagramFrame when a frame's
+// length prefix exceeds maxDatagramFrameSize.
+var errDatagramTooLarge = errors.New("ingress: udp datagram frame exceeds max size")
+
+// isUDPService reports whether rawURL names a udp:// origin, the ingress
+// rule scheme that dials through DialUDPOrigin instead of the tcp:// path.
+func isUDPService(rawURL string) bool {
+	return strings.HasPrefix(rawURL, udpServiceScheme)
+}
+
+// DialUDPOrigin resolves and dials originAddr (as configured by a udp://
+// ingress rule) and returns the OriginConnection that multiplexes datagrams
+// to it over WS.
+func DialUDPOrigin(originAddr string, idleTimeout time.Duration) (OriginConnection, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", originAddr)
+	if err != nil {
+		return nil, err
+	}
+	return newUDPOverWSConnection(udpAddr, idleTimeout)
+}
+
+// udpOverWSConnection is an OriginConnection that multiplexes UDP datagrams
+// between the eyeball and a single origin UDP socket over the WebSocket
+// tunnel connection, using 2-byte big-endian length-prefixed frames. This
+// lets UDP workloads such as DNS, QUIC, syslog, and game servers traverse
+// the tunnel.
+type udpOverWSConnection struct {
+	conn        *net.UDPConn
+	idleTimeout time.Duration
+}
+
+// newUDPOverWSConnection dials a UDP socket to originAddr so its datagrams
+// can be streamed over WS. idleTimeout of zero selects
+// defaultUDPIdleTimeout.
+func newUDPOverWSConnection(originAddr *net.UDPAddr, idleTimeout time.Duration) (*udpOverWSConnection, error) {
+	conn, err := net.DialUDP("udp", nil, originAddr)
+	if err != nil {
+		return nil, err
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = defaultUDPIdleTimeout
+	}
+	return &udpOverWSConnection{
+		conn:        conn,
+		idleTimeout: idleTimeout,
+	}, nil
+}
+
+func (uc *udpOverWSConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger, opts *StreamOptions) StreamStats {
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+
+	wsConn := websocket.NewConn(ctx, tunnelConn, log)
+
+	idleTimeout := uc.idleTimeout
+	if opts.IdleTimeout > 0 {
+		idleTimeout = opts.IdleTimeout
+	}
+
+	var closeOnce sync.Once
+	closeConn := func() {
+		closeOnce.Do(func() { uc.conn.Close() })
+	}
+
+	reason := &reasonTracker{}
+
+	// idleTimer is driven by time.AfterFunc rather than a channel read, so
+	// touch may safely be called concurrently from both copy goroutines
+	// below; a plain time.Timer's channel is not safe to Reset while
+	// another goroutine is receiving on it.
+	idle := newIdleTimer(idleTimeout, func() {
+		reason.setIfEmpty("idle_timeout")
+		closeConn()
+	})
+	defer idle.Stop()
+
+	var bytesOut, bytesIn atomic.Int64 // origin->eyeball, eyeball->origin
+	proxyDone := make(chan struct{}, 2)
+
+	// origin -> eyeball: read a UDP datagram and frame it onto the WS conn.
+	go func() {
+		buf := make([]byte, maxDatagramFrameSize)
+		for {
+			if opts.MaxBytes > 0 && bytesOut.Load() >= opts.MaxBytes {
+				reason.setIfEmpty("max_bytes")
+				break
+			}
+			n, err := uc.conn.Read(buf)
+			if err != nil {
+				log.Debug().Msgf("udp origin to ws copy: %v", err)
+				break
+			}
+			idle.touch()
+			bytesOut.Add(int64(n))
+			if err := writeDatagramFrame(wsConn, buf[:n]); err != nil {
+				log.Debug().Msgf("udp origin to ws write: %v", err)
+				break
+			}
+		}
+		proxyDone <- struct{}{}
+	}()
+
+	// eyeball -> origin: read a framed datagram off the WS conn and write it to the UDP socket.
+	go func() {
+		for {
+			if opts.MaxBytes > 0 && bytesIn.Load() >= opts.MaxBytes {
+				reason.setIfEmpty("max_bytes")
+				break
+			}
+			payload, err := readDatagramFrame(wsConn)
+			if err != nil {
+				log.Debug().Msgf("udp ws to origin copy: %v", err)
+				break
+			}
+			idle.touch()
+			bytesIn.Add(int64(len(payload)))
+			if _, err := uc.conn.Write(payload); err != nil {
+				log.Debug().Msgf("udp ws to origin write: %v", err)
+				break
+			}
+		}
+		proxyDone <- struct{}{}
+	}()
+
+	// If one side is done, we are done.
+	<-proxyDone
+	closeConn()
+
+	stats := StreamStats{
+		BytesIn:  bytesIn.Load(),
+		BytesOut: bytesOut.Load(),
+		Reason:   reason.get(),
+	}
+	if opts.Metrics != nil {
+		opts.Metrics.Finished(stats)
+	}
+	return stats
+}
+
+func (uc *udpOverWSConnection) Close() {
+	uc.conn.Close()
+}
+
+// writeDatagramFrame writes a single datagram to w as a 2-byte big-endian
+// length prefix followed by the payload.
+func writeDatagramFrame(w io.Writer, payload []byte) error {
+	var lengthPrefix [2]byte
+	binary.BigEndian.PutUint16(lengthPrefix[:], uint16(len(payload)))
+	if _, err := w.Write(lengthPrefix[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readDatagramFrame reads a single length-prefixed datagram from r.
+func readDatagramFrame(r io.Reader) ([]byte, error) {
+	var lengthPrefix [2]byte
+	if _, err := io.ReadFull(r, lengthPrefix[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint16(lengthPrefix[:])
+	if int(length) > maxDatagramFrameSize {
+		return nil, errDatagramTooLarge
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
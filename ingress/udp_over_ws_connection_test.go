@@ -0,0 +1,149 @@
+package ingress
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws/wsutil"
+	"github.com/rs/zerolog"
+)
+
+// wsClientConn plays the eyeball side of the tunnel: each Write is sent as
+// one masked client binary message and each Read returns one server binary
+// message, matching the framing websocket.NewConn uses on the origin side.
+type wsClientConn struct {
+	rw io.ReadWriter
+}
+
+func (c wsClientConn) Read(p []byte) (int, error) {
+	data, err := wsutil.ReadServerBinary(c.rw)
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, data), nil
+}
+
+func (c wsClientConn) Write(p []byte) (int, error) {
+	if err := wsutil.WriteClientBinary(c.rw, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func TestWriteAndReadDatagramFrameRoundTrip(t *testing.T) {
+	tests := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("hello, datagram"),
+		bytes.Repeat([]byte{0xAB}, maxDatagramFrameSize),
+	}
+	for _, payload := range tests {
+		var buf bytes.Buffer
+		if err := writeDatagramFrame(&buf, payload); err != nil {
+			t.Fatalf("writeDatagramFrame: %v", err)
+		}
+
+		wantPrefix := make([]byte, 2)
+		binary.BigEndian.PutUint16(wantPrefix, uint16(len(payload)))
+		if !bytes.Equal(buf.Bytes()[:2], wantPrefix) {
+			t.Fatalf("length prefix = %x, want %x", buf.Bytes()[:2], wantPrefix)
+		}
+
+		got, err := readDatagramFrame(&buf)
+		if err != nil {
+			t.Fatalf("readDatagramFrame: %v", err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("round-tripped payload = %x, want %x", got, payload)
+		}
+	}
+}
+
+func TestReadDatagramFrameRejectsOversizedLengthPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	var prefix [2]byte
+	binary.BigEndian.PutUint16(prefix[:], uint16(maxDatagramFrameSize+1))
+	buf.Write(prefix[:])
+
+	if _, err := readDatagramFrame(&buf); err != errDatagramTooLarge {
+		t.Fatalf("err = %v, want errDatagramTooLarge", err)
+	}
+}
+
+func TestReadDatagramFrameShortRead(t *testing.T) {
+	var buf bytes.Buffer
+	var prefix [2]byte
+	binary.BigEndian.PutUint16(prefix[:], 10)
+	buf.Write(prefix[:])
+	buf.WriteString("short") // fewer than the 10 bytes promised
+
+	if _, err := readDatagramFrame(&buf); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+// TestDialOriginForServiceUDP exercises the rule-parser entry point for a
+// udp:// service URL end to end: it dials a real loopback UDP listener and
+// round-trips a datagram through the resulting OriginConnection's Stream.
+func TestDialOriginForServiceUDP(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer echo.Close()
+
+	go func() {
+		buf := make([]byte, maxDatagramFrameSize)
+		for {
+			n, addr, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	rawServiceURL := "udp://" + echo.LocalAddr().String()
+	conn, _, err := DialOriginForService(context.Background(), rawServiceURL, nil, ProxyProtocolNone, 2*time.Second)
+	if err != nil {
+		t.Fatalf("DialOriginForService: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*udpOverWSConnection); !ok {
+		t.Fatalf("got %T, want *udpOverWSConnection", conn)
+	}
+
+	tunnelSide, tunnelDriver := net.Pipe()
+	defer tunnelDriver.Close()
+
+	log := zerolog.Nop()
+	done := make(chan StreamStats, 1)
+	go func() {
+		done <- conn.Stream(context.Background(), tunnelSide, &log, nil)
+	}()
+
+	eyeball := wsClientConn{rw: tunnelDriver}
+	payload := []byte("hello udp origin")
+	if err := writeDatagramFrame(eyeball, payload); err != nil {
+		t.Fatalf("writeDatagramFrame: %v", err)
+	}
+	echoed, err := readDatagramFrame(eyeball)
+	if err != nil {
+		t.Fatalf("readDatagramFrame: %v", err)
+	}
+	if !bytes.Equal(echoed, payload) {
+		t.Fatalf("echoed payload = %q, want %q", echoed, payload)
+	}
+
+	tunnelDriver.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not return after the tunnel side closed")
+	}
+}